@@ -18,7 +18,6 @@ package kuberuntime
 
 import (
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -32,12 +31,10 @@ import (
 	"k8s.io/kubernetes/pkg/api/unversioned"
 	runtimeApi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
 	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
-	"k8s.io/kubernetes/pkg/kubelet/dockershim"
 	"k8s.io/kubernetes/pkg/kubelet/events"
 	"k8s.io/kubernetes/pkg/kubelet/util/format"
 	"k8s.io/kubernetes/pkg/types"
 	utilruntime "k8s.io/kubernetes/pkg/util/runtime"
-	"k8s.io/kubernetes/pkg/util/term"
 )
 
 // startContainer starts a container and returns a message indicates why it is failed on error.
@@ -47,8 +44,17 @@ import (
 // * start the container
 // * run the post start lifecycle hooks (if applicable)
 func (m *kubeGenericRuntimeManager) startContainer(podSandboxID string, podSandboxConfig *runtimeApi.PodSandboxConfig, container *api.Container, pod *api.Pod, podStatus *kubecontainer.PodStatus, pullSecrets []api.Secret, podIP string) (string, error) {
-	// Step 1: pull the image.
-	err, msg := m.imagePuller.EnsureImageExists(pod, container, pullSecrets)
+	// Step 1: pull the image. This goes through imagePullManager rather than
+	// imagePuller directly so that many pods landing on the node at once
+	// don't each kick off their own unbounded, unserialized pull. Fall back
+	// to imagePuller directly if no manager was configured.
+	var err error
+	var msg string
+	if m.imagePullManager != nil {
+		err, msg = m.imagePullManager.EnsureImageExists(pod, container, pullSecrets)
+	} else {
+		err, msg = m.imagePuller.EnsureImageExists(pod, container, pullSecrets)
+	}
 	if err != nil {
 		return msg, err
 	}
@@ -85,7 +91,23 @@ func (m *kubeGenericRuntimeManager) startContainer(podSandboxID string, podSandb
 		}, ref)
 	}
 
-	// Step 3: start the container.
+	kubeContainerID := kubecontainer.ContainerID{Type: m.runtimeName, ID: containerID}
+
+	// Step 3: run matching OCI prestart hooks.
+	//
+	// NOTE: this is a simplification. A real OCI prestart hook runs inside
+	// the container's own namespace setup, invoked by the runtime/shim as
+	// part of creating it (e.g. via a field on runtimeApi.ContainerConfig or
+	// an OCI-spec annotation the shim consumes), so it can see and modify
+	// the container's mount/network namespaces before the user process
+	// starts. Here it runs identically to poststart/poststop: a plain
+	// os/exec.Command on the kubelet host, after the container already
+	// exists. That's the wrong execution context for hooks that need to act
+	// inside the container's namespaces, and is only adequate for hooks that
+	// only need the OCI state JSON and host-side access.
+	m.runHooks(ociHookPreStart, pod, container, kubeContainerID, "created")
+
+	// Step 4: start the container.
 	err = m.runtimeService.StartContainer(containerID)
 	if err != nil {
 		m.recorder.Eventf(ref, api.EventTypeWarning, events.FailedToStartContainer,
@@ -94,12 +116,12 @@ func (m *kubeGenericRuntimeManager) startContainer(podSandboxID string, podSandb
 	}
 	m.recorder.Eventf(ref, api.EventTypeNormal, events.StartedContainer, "Started container with id %v", containerID)
 
-	// Step 4: execute the post start hook.
+	// Step 5: run matching OCI poststart hooks, alongside the pod spec's own
+	// PostStart lifecycle handler below.
+	m.runHooks(ociHookPostStart, pod, container, kubeContainerID, "running")
+
+	// Step 6: execute the post start hook.
 	if container.Lifecycle != nil && container.Lifecycle.PostStart != nil {
-		kubeContainerID := kubecontainer.ContainerID{
-			Type: m.runtimeName,
-			ID:   containerID,
-		}
 		msg, handlerErr := m.runner.Run(kubeContainerID, pod, container, container.Lifecycle.PostStart)
 		if handlerErr != nil {
 			err := fmt.Errorf("PostStart handler: %v", handlerErr)
@@ -172,17 +194,17 @@ func (m *kubeGenericRuntimeManager) generateContainerConfig(container *api.Conta
 	return config, nil
 }
 
-// generateLinuxContainerConfig generates linux container config for kubelet runtime api.
-func (m *kubeGenericRuntimeManager) generateLinuxContainerConfig(container *api.Container) *runtimeApi.LinuxContainerConfig {
-	linuxConfig := &runtimeApi.LinuxContainerConfig{
-		Resources: &runtimeApi.LinuxContainerResources{},
-	}
+// generateLinuxContainerResources converts an api.ResourceRequirements into
+// CRI LinuxContainerResources using the same milliCPU-to-shares/quota
+// helpers, so create (generateLinuxContainerConfig) and update
+// (UpdateContainerResources) never drift from each other.
+func (m *kubeGenericRuntimeManager) generateLinuxContainerResources(resources api.ResourceRequirements) *runtimeApi.LinuxContainerResources {
+	linuxResources := &runtimeApi.LinuxContainerResources{}
 
-	// set linux container resources
 	var cpuShares int64
-	cpuRequest := container.Resources.Requests.Cpu()
-	cpuLimit := container.Resources.Limits.Cpu()
-	memoryLimit := container.Resources.Limits.Memory().Value()
+	cpuRequest := resources.Requests.Cpu()
+	cpuLimit := resources.Limits.Cpu()
+	memoryLimit := resources.Limits.Memory().Value()
 	// If request is not specified, but limit is, we want request to default to limit.
 	// API server does this for new containers, but we repeat this logic in Kubelet
 	// for containers running on existing Kubernetes clusters.
@@ -193,16 +215,25 @@ func (m *kubeGenericRuntimeManager) generateLinuxContainerConfig(container *api.
 		// of CPU shares.
 		cpuShares = milliCPUToShares(cpuRequest.MilliValue())
 	}
-	linuxConfig.Resources.CpuShares = &cpuShares
+	linuxResources.CpuShares = &cpuShares
 	if memoryLimit != 0 {
-		linuxConfig.Resources.MemoryLimitInBytes = &memoryLimit
+		linuxResources.MemoryLimitInBytes = &memoryLimit
 	}
 	if m.cpuCFSQuota {
 		// if cpuLimit.Amount is nil, then the appropriate default value is returned
 		// to allow full usage of cpu resource.
 		cpuQuota, cpuPeriod := milliCPUToQuota(cpuLimit.MilliValue())
-		linuxConfig.Resources.CpuQuota = &cpuQuota
-		linuxConfig.Resources.CpuPeriod = &cpuPeriod
+		linuxResources.CpuQuota = &cpuQuota
+		linuxResources.CpuPeriod = &cpuPeriod
+	}
+
+	return linuxResources
+}
+
+// generateLinuxContainerConfig generates linux container config for kubelet runtime api.
+func (m *kubeGenericRuntimeManager) generateLinuxContainerConfig(container *api.Container) *runtimeApi.LinuxContainerConfig {
+	linuxConfig := &runtimeApi.LinuxContainerConfig{
+		Resources: m.generateLinuxContainerResources(container.Resources),
 	}
 
 	// set security context options
@@ -338,6 +369,18 @@ func getTerminationMessage(status *runtimeApi.ContainerStatus, kubeStatus *kubec
 	return message
 }
 
+// defaultContainerStatusWorkers is how many ContainerStatus RPCs
+// getKubeletContainerStatuses fans out at once when
+// kubeGenericRuntimeManager.containerStatusWorkers isn't set.
+const defaultContainerStatusWorkers = 8
+
+// defaultMaxExitedContainerStatusesPerContainer bounds, per container name,
+// how many non-latest exited containers getKubeletContainerStatuses fetches
+// status for, when kubeGenericRuntimeManager.maxExitedContainerStatusesPerContainer
+// isn't set. Nodes that run pods with many restarts can otherwise accumulate
+// a long restart history whose status is never looked at again.
+const defaultMaxExitedContainerStatusesPerContainer = 5
+
 // getKubeletContainerStatuses gets all containers' status for the pod sandbox.
 func (m *kubeGenericRuntimeManager) getKubeletContainerStatuses(podSandboxID string) ([]*kubecontainer.ContainerStatus, error) {
 	containers, err := m.runtimeService.ListContainers(&runtimeApi.ContainerFilter{
@@ -348,45 +391,123 @@ func (m *kubeGenericRuntimeManager) getKubeletContainerStatuses(podSandboxID str
 		return nil, err
 	}
 
+	containers = m.capExitedContainersPerName(containers)
+
 	statuses := make([]*kubecontainer.ContainerStatus, len(containers))
-	// TODO: optimization: set maximum number of containers per container name to examine.
-	for i, c := range containers {
-		status, err := m.runtimeService.ContainerStatus(c.GetId())
+	errs := make([]error, len(containers))
+
+	workers := m.containerStatusWorkers
+	if workers <= 0 {
+		workers = defaultContainerStatusWorkers
+	}
+	if workers > len(containers) {
+		workers = len(containers)
+	}
+
+	indices := make(chan int, len(containers))
+	for i := range containers {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			defer utilruntime.HandleCrash()
+			for i := range indices {
+				// Writing into a pre-allocated, indexed slice (rather than
+				// appending from a result channel) keeps the output in the
+				// same order as containers regardless of which worker
+				// finishes first.
+				statuses[i], errs[i] = m.containerStatusFromCRI(containers[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
 		if err != nil {
-			glog.Errorf("ContainerStatus for %s error: %v", c.GetId(), err)
 			return nil, err
 		}
+	}
 
-		annotatedInfo := getContainerInfoFromAnnotations(c.Annotations)
-		labeledInfo := getContainerInfoFromLabels(c.Labels)
-		cStatus := &kubecontainer.ContainerStatus{
-			ID: kubecontainer.ContainerID{
-				Type: m.runtimeName,
-				ID:   c.GetId(),
-			},
-			Name:         labeledInfo.ContainerName,
-			Image:        status.Image.GetImage(),
-			ImageID:      status.GetImageRef(),
-			Hash:         annotatedInfo.Hash,
-			RestartCount: annotatedInfo.RestartCount,
-			State:        toKubeContainerState(c.GetState()),
-			CreatedAt:    time.Unix(status.GetCreatedAt(), 0),
-		}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].CreatedAt.Before(statuses[j].CreatedAt)
+	})
+	return statuses, nil
+}
 
-		if c.GetState() == runtimeApi.ContainerState_RUNNING {
-			cStatus.StartedAt = time.Unix(status.GetStartedAt(), 0)
-		} else {
-			cStatus.Reason = status.GetReason()
-			cStatus.ExitCode = int(status.GetExitCode())
-			cStatus.FinishedAt = time.Unix(status.GetFinishedAt(), 0)
-		}
+// containerStatusFromCRI fetches and translates a single container's CRI
+// status; it's the per-container body getKubeletContainerStatuses used to
+// run sequentially and now runs on a bounded worker pool instead.
+func (m *kubeGenericRuntimeManager) containerStatusFromCRI(c *runtimeApi.Container) (*kubecontainer.ContainerStatus, error) {
+	status, err := m.runtimeService.ContainerStatus(c.GetId())
+	if err != nil {
+		glog.Errorf("ContainerStatus for %s error: %v", c.GetId(), err)
+		return nil, err
+	}
 
-		cStatus.Message = getTerminationMessage(status, cStatus, annotatedInfo.TerminationMessagePath)
-		statuses[i] = cStatus
+	annotatedInfo := getContainerInfoFromAnnotations(c.Annotations)
+	labeledInfo := getContainerInfoFromLabels(c.Labels)
+	cStatus := &kubecontainer.ContainerStatus{
+		ID: kubecontainer.ContainerID{
+			Type: m.runtimeName,
+			ID:   c.GetId(),
+		},
+		Name:         labeledInfo.ContainerName,
+		Image:        status.Image.GetImage(),
+		ImageID:      status.GetImageRef(),
+		Hash:         annotatedInfo.Hash,
+		RestartCount: annotatedInfo.RestartCount,
+		State:        toKubeContainerState(c.GetState()),
+		CreatedAt:    time.Unix(status.GetCreatedAt(), 0),
 	}
 
-	sort.Sort(containerStatusByCreated(statuses))
-	return statuses, nil
+	if c.GetState() == runtimeApi.ContainerState_RUNNING {
+		cStatus.StartedAt = time.Unix(status.GetStartedAt(), 0)
+	} else {
+		cStatus.Reason = status.GetReason()
+		cStatus.ExitCode = int(status.GetExitCode())
+		cStatus.FinishedAt = time.Unix(status.GetFinishedAt(), 0)
+	}
+
+	cStatus.Message = getTerminationMessage(status, cStatus, annotatedInfo.TerminationMessagePath)
+	return cStatus, nil
+}
+
+// capExitedContainersPerName sorts containers by creation time, newest
+// first, then drops exited containers beyond the configured limit for their
+// container name so a long restart history doesn't cost a ContainerStatus
+// RPC per entry. Running containers are never dropped.
+func (m *kubeGenericRuntimeManager) capExitedContainersPerName(containers []*runtimeApi.Container) []*runtimeApi.Container {
+	limit := m.maxExitedContainerStatusesPerContainer
+	if limit <= 0 {
+		limit = defaultMaxExitedContainerStatusesPerContainer
+	}
+
+	sorted := make([]*runtimeApi.Container, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetCreatedAt() > sorted[j].GetCreatedAt()
+	})
+
+	exitedSeen := make(map[string]int)
+	kept := make([]*runtimeApi.Container, 0, len(sorted))
+	for _, c := range sorted {
+		if c.GetState() == runtimeApi.ContainerState_RUNNING {
+			kept = append(kept, c)
+			continue
+		}
+		name := getContainerInfoFromLabels(c.Labels).ContainerName
+		if exitedSeen[name] >= limit {
+			continue
+		}
+		exitedSeen[name]++
+		kept = append(kept, c)
+	}
+	return kept
 }
 
 // generateContainerEvent generates an event for the container.
@@ -460,6 +581,10 @@ func (m *kubeGenericRuntimeManager) killContainer(pod *api.Pod, containerID kube
 		glog.V(3).Infof("Container %q exited normally", containerID.String())
 	}
 
+	if pod != nil && containerSpec != nil {
+		m.runHooks(ociHookPostStop, pod, containerSpec, containerID, "stopped")
+	}
+
 	message := fmt.Sprintf("Killing container with id %s", containerID.String())
 	if reason != "" {
 		message = fmt.Sprint(message, ":", reason)
@@ -507,37 +632,6 @@ func (m *kubeGenericRuntimeManager) killContainersWithSyncResult(pod *api.Pod, r
 	return
 }
 
-// AttachContainer attaches to the container's console
-func (m *kubeGenericRuntimeManager) AttachContainer(id kubecontainer.ContainerID, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) (err error) {
-	return fmt.Errorf("not implemented")
-}
-
-// GetContainerLogs returns logs of a specific container.
-func (m *kubeGenericRuntimeManager) GetContainerLogs(pod *api.Pod, containerID kubecontainer.ContainerID, logOptions *api.PodLogOptions, stdout, stderr io.Writer) (err error) {
-	// Get logs directly from docker for in-process docker integration for
-	// now to unblock other tests.
-	// TODO: remove this hack after setting down on how to implement log
-	// retrieval/management.
-	if ds, ok := m.runtimeService.(dockershim.DockerLegacyService); ok {
-		return ds.GetContainerLogs(pod, containerID, logOptions, stdout, stderr)
-	}
-	return fmt.Errorf("not implemented")
-}
-
-// Runs the command in the container of the specified pod using nsenter.
-// Attaches the processes stdin, stdout, and stderr. Optionally uses a
-// tty.
-// TODO: handle terminal resizing, refer https://github.com/kubernetes/kubernetes/issues/29579
-func (m *kubeGenericRuntimeManager) ExecInContainer(containerID kubecontainer.ContainerID, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error {
-	// Use `docker exec` directly for in-process docker integration for
-	// now to unblock other tests.
-	// TODO: remove this hack after exec is defined in CRI.
-	if ds, ok := m.runtimeService.(dockershim.DockerLegacyService); ok {
-		return ds.ExecInContainer(containerID, cmd, stdin, stdout, stderr, tty, resize)
-	}
-	return fmt.Errorf("not implemented")
-}
-
 // DeleteContainer removes a container.
 func (m *kubeGenericRuntimeManager) DeleteContainer(containerID kubecontainer.ContainerID) error {
 	return m.runtimeService.RemoveContainer(containerID.ID)
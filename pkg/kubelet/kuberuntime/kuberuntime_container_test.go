@@ -0,0 +1,161 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	runtimeApi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+)
+
+// latencyInjectingRuntimeService wraps a fixed set of containers/statuses
+// and sleeps latency on every ContainerStatus call, so tests and benchmarks
+// can see the effect of fanning those calls out across workers instead of
+// making them one at a time.
+type latencyInjectingRuntimeService struct {
+	runtimeApi.RuntimeService
+
+	latency    time.Duration
+	containers []*runtimeApi.Container
+	statuses   map[string]*runtimeApi.ContainerStatus
+}
+
+func (f *latencyInjectingRuntimeService) ListContainers(filter *runtimeApi.ContainerFilter) ([]*runtimeApi.Container, error) {
+	return f.containers, nil
+}
+
+func (f *latencyInjectingRuntimeService) ContainerStatus(containerID string) (*runtimeApi.ContainerStatus, error) {
+	time.Sleep(f.latency)
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return nil, fmt.Errorf("no status fixture for container %q", containerID)
+	}
+	return status, nil
+}
+
+func newTestContainer(id string, name string, state runtimeApi.ContainerState, createdAt int64) (*runtimeApi.Container, *runtimeApi.ContainerStatus) {
+	running := state
+	c := &runtimeApi.Container{
+		Id:        &id,
+		State:     &running,
+		CreatedAt: &createdAt,
+		Labels:    map[string]string{"io.kubernetes.container.name": name},
+	}
+	status := &runtimeApi.ContainerStatus{
+		Id:        &id,
+		State:     &running,
+		CreatedAt: &createdAt,
+	}
+	return c, status
+}
+
+func newFakeKubeGenericRuntimeManager(runtimeService runtimeApi.RuntimeService) *kubeGenericRuntimeManager {
+	return &kubeGenericRuntimeManager{
+		runtimeName:    "fake",
+		runtimeService: runtimeService,
+	}
+}
+
+func TestGetKubeletContainerStatusesPreservesOrder(t *testing.T) {
+	var containers []*runtimeApi.Container
+	statuses := map[string]*runtimeApi.ContainerStatus{}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("container-%d", i)
+		c, status := newTestContainer(id, "app", runtimeApi.ContainerState_RUNNING, int64(i))
+		containers = append(containers, c)
+		statuses[id] = status
+	}
+
+	fake := &latencyInjectingRuntimeService{containers: containers, statuses: statuses}
+	m := newFakeKubeGenericRuntimeManager(fake)
+	m.containerStatusWorkers = 8
+
+	results, err := m.getKubeletContainerStatuses("sandbox")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != len(containers) {
+		t.Fatalf("expected %d statuses, got %d", len(containers), len(results))
+	}
+	// getKubeletContainerStatuses sorts ascending by CreatedAt; verify the
+	// concurrent fetch didn't scramble that ordering.
+	for i := 1; i < len(results); i++ {
+		if results[i-1].CreatedAt.After(results[i].CreatedAt) {
+			t.Fatalf("statuses out of order at index %d: %v after %v", i, results[i-1].CreatedAt, results[i].CreatedAt)
+		}
+	}
+}
+
+func TestCapExitedContainersPerName(t *testing.T) {
+	m := newFakeKubeGenericRuntimeManager(nil)
+	m.maxExitedContainerStatusesPerContainer = 2
+
+	var containers []*runtimeApi.Container
+	for i := 0; i < 5; i++ {
+		c, _ := newTestContainer(fmt.Sprintf("exited-%d", i), "app", runtimeApi.ContainerState_EXITED, int64(i))
+		containers = append(containers, c)
+	}
+	runningC, _ := newTestContainer("running-0", "app", runtimeApi.ContainerState_RUNNING, int64(100))
+	containers = append(containers, runningC)
+
+	kept := m.capExitedContainersPerName(containers)
+
+	exitedKept := 0
+	runningKept := 0
+	for _, c := range kept {
+		if c.GetState() == runtimeApi.ContainerState_RUNNING {
+			runningKept++
+		} else {
+			exitedKept++
+		}
+	}
+	if exitedKept != 2 {
+		t.Errorf("expected 2 exited containers kept (most recent), got %d", exitedKept)
+	}
+	if runningKept != 1 {
+		t.Errorf("expected the running container to always be kept, got %d", runningKept)
+	}
+}
+
+func BenchmarkGetKubeletContainerStatuses(b *testing.B) {
+	const numContainers = 100
+	var containers []*runtimeApi.Container
+	statuses := map[string]*runtimeApi.ContainerStatus{}
+	for i := 0; i < numContainers; i++ {
+		id := fmt.Sprintf("container-%d", i)
+		c, status := newTestContainer(id, fmt.Sprintf("app-%d", i), runtimeApi.ContainerState_RUNNING, int64(i))
+		containers = append(containers, c)
+		statuses[id] = status
+	}
+
+	fake := &latencyInjectingRuntimeService{
+		latency:    time.Millisecond,
+		containers: containers,
+		statuses:   statuses,
+	}
+	m := newFakeKubeGenericRuntimeManager(fake)
+	m.containerStatusWorkers = 8
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.getKubeletContainerStatuses("sandbox"); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"gopkg.in/fsnotify.v1"
+	"k8s.io/kubernetes/pkg/api"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+)
+
+// defaultHooksDirs are searched, in order, for OCI hook definitions, mirroring
+// CRI-O's libkpod/hooks.go. Later directories win on a name collision so a
+// cluster admin can override the distro defaults in /etc.
+var defaultHooksDirs = []string{
+	"/usr/share/containers/oci/hooks.d",
+	"/etc/containers/oci/hooks.d",
+}
+
+// defaultHookTimeout is the fallback used when newOCIHookManager is given no
+// timeout: how long kubelet waits for a single hook to finish before killing
+// it and continuing, so a hung hook never wedges the pod lifecycle.
+const defaultHookTimeout = 10 * time.Second
+
+// failedOCIHookEvent is the event reason reported when any OCI hook stage
+// fails. It's distinct from events.FailedPostStartHook, which is reserved
+// for the pod spec's own container.Lifecycle.PostStart handler, so a failed
+// poststop (or prestart/poststart) hook doesn't masquerade as a failed
+// PostStart handler in `kubectl describe pod`.
+const failedOCIHookEvent = "FailedOCIHook"
+
+// ociHookStage is one of the three points in a container's lifecycle a hook
+// can be registered for.
+type ociHookStage string
+
+const (
+	ociHookPreStart  ociHookStage = "prestart"
+	ociHookPostStart ociHookStage = "poststart"
+	ociHookPostStop  ociHookStage = "poststop"
+)
+
+// ociHookMatch holds the predicates CRI-O-style hook definitions use to
+// decide whether they apply to a given pod/container.
+type ociHookMatch struct {
+	// Image, if set, is matched against the container's image name.
+	Image string `json:"image,omitempty"`
+	// Annotation, if set, is matched against the pod's annotation keys.
+	Annotation string `json:"annotation,omitempty"`
+	// HasBindMounts, if true, only matches containers with at least one
+	// bind mount.
+	HasBindMounts bool `json:"hasBindMounts,omitempty"`
+}
+
+// ociHook is the on-disk JSON schema for a single hook definition.
+type ociHook struct {
+	Stages    []ociHookStage `json:"stages"`
+	Hook      string         `json:"hook"`
+	Arguments []string       `json:"arguments,omitempty"`
+	Match     ociHookMatch   `json:"match,omitempty"`
+}
+
+func (h *ociHook) hasStage(stage ociHookStage) bool {
+	for _, s := range h.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether h applies to pod/container, evaluating the same
+// three predicates CRI-O's hook matcher supports. An empty match block
+// matches everything.
+func (h *ociHook) matches(pod *api.Pod, container *api.Container, hasBindMounts bool) bool {
+	if h.Match.Image != "" {
+		re, err := regexp.Compile(h.Match.Image)
+		if err != nil || !re.MatchString(container.Image) {
+			return false
+		}
+	}
+	if h.Match.Annotation != "" {
+		re, err := regexp.Compile(h.Match.Annotation)
+		if err != nil {
+			return false
+		}
+		found := false
+		for k := range pod.Annotations {
+			if re.MatchString(k) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if h.Match.HasBindMounts && !hasBindMounts {
+		return false
+	}
+	return true
+}
+
+// hookEntry is the hook currently active for a given basename, along with
+// the path it was loaded from so a later override (or the removal of one)
+// can be resolved against dirs priority.
+type hookEntry struct {
+	hook *ociHook
+	path string
+}
+
+// ociHookManager loads and evaluates OCI lifecycle hooks from a set of
+// watched directories, following the pattern CRI-O's libkpod/hooks.go uses:
+// hooks are plain JSON files naming a command, the stages it runs at, and
+// match predicates over the pod/container.
+type ociHookManager struct {
+	dirs    []string
+	timeout time.Duration
+
+	mu    sync.RWMutex
+	hooks map[string]*hookEntry // keyed by file basename; later dirs win
+
+	watcher *fsnotify.Watcher
+}
+
+// newOCIHookManager loads every hook currently on disk in dirs and starts
+// watching them for add/remove so hooks can be deployed without restarting
+// kubelet. An empty dirs falls back to defaultHooksDirs. timeout bounds how
+// long a single
+// hook invocation is allowed to run; timeout <= 0 falls back to
+// defaultHookTimeout.
+func newOCIHookManager(dirs []string, timeout time.Duration) (*ociHookManager, error) {
+	if len(dirs) == 0 {
+		dirs = defaultHooksDirs
+	}
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	m := &ociHookManager{
+		dirs:    dirs,
+		timeout: timeout,
+		hooks:   make(map[string]*hookEntry),
+	}
+	for _, dir := range dirs {
+		if err := m.loadDir(dir); err != nil {
+			glog.Warningf("Error loading OCI hooks from %q: %v", dir, err)
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCI hooks watcher: %v", err)
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			glog.Warningf("Not watching OCI hooks directory %q: %v", dir, err)
+		}
+	}
+	m.watcher = watcher
+	go m.watchLoop()
+
+	return m, nil
+}
+
+func (m *ociHookManager) loadDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		m.loadFile(filepath.Join(dir, entry.Name()))
+	}
+	return nil
+}
+
+// dirIndex returns path's position in m.dirs (higher index overrides lower),
+// or -1 if path isn't inside any configured directory.
+func (m *ociHookManager) dirIndex(path string) int {
+	dir := filepath.Dir(path)
+	for i, d := range m.dirs {
+		if d == dir {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadFile parses the hook at path and, if its directory's priority is at
+// least as high as whatever currently owns that basename, installs it. This
+// is what makes two hook files sharing a basename in both
+// /usr/share/containers/oci/hooks.d and /etc/containers/oci/hooks.d behave
+// as an override rather than both running.
+func (m *ociHookManager) loadFile(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		glog.Warningf("Error reading OCI hook file %q: %v", path, err)
+		return
+	}
+	hook := &ociHook{}
+	if err := json.Unmarshal(data, hook); err != nil {
+		glog.Warningf("Error parsing OCI hook file %q: %v", path, err)
+		return
+	}
+
+	basename := filepath.Base(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.hooks[basename]; ok && existing.path != path && m.dirIndex(path) < m.dirIndex(existing.path) {
+		glog.V(4).Infof("OCI hook %q shadowed by higher-priority override %q, ignoring", path, existing.path)
+		return
+	}
+	m.hooks[basename] = &hookEntry{hook: hook, path: path}
+}
+
+// removeFile drops the hook at path if it's the one currently active for
+// its basename, then falls back to the next-highest-priority directory that
+// still has a file with that basename, if any, so deleting an override
+// un-shadows the file it was overriding rather than leaving the basename
+// unregistered.
+func (m *ociHookManager) removeFile(path string) {
+	basename := filepath.Base(path)
+
+	m.mu.Lock()
+	existing, ok := m.hooks[basename]
+	if !ok || existing.path != path {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.hooks, basename)
+	m.mu.Unlock()
+
+	for i := len(m.dirs) - 1; i >= 0; i-- {
+		if m.dirs[i] == filepath.Dir(path) {
+			continue
+		}
+		candidate := filepath.Join(m.dirs[i], basename)
+		if _, err := ioutil.ReadFile(candidate); err == nil {
+			m.loadFile(candidate)
+			return
+		}
+	}
+}
+
+// watchLoop reloads individual hook files as they're created, modified, or
+// removed so an admin can add/update/delete hooks without a kubelet
+// restart.
+func (m *ociHookManager) watchLoop() {
+	for {
+		select {
+		case event, ok := <-m.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Ext(event.Name) != ".json" {
+				continue
+			}
+			switch {
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				m.removeFile(event.Name)
+			case event.Op&(fsnotify.Create|fsnotify.Write) != 0:
+				m.loadFile(event.Name)
+			}
+		case err, ok := <-m.watcher.Errors:
+			if !ok {
+				return
+			}
+			glog.Warningf("OCI hooks watcher error: %v", err)
+		}
+	}
+}
+
+// matchingHooks returns, ordered by hook file basename, the hooks registered
+// for stage that match pod/container.
+func (m *ociHookManager) matchingHooks(stage ociHookStage, pod *api.Pod, container *api.Container, hasBindMounts bool) []*ociHook {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.hooks))
+	for name := range m.hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var matched []*ociHook
+	for _, name := range names {
+		entry := m.hooks[name]
+		if entry.hook.hasStage(stage) && entry.hook.matches(pod, container, hasBindMounts) {
+			matched = append(matched, entry.hook)
+		}
+	}
+	return matched
+}
+
+// containerHasBindMounts reports whether any of container's VolumeMounts
+// resolves to a pod Volume backed by the host filesystem (a HostPath
+// volume), i.e. an actual OCI bind mount. This is a property of the volume
+// source, not of whether the mount happens to be read-only: a read-only
+// HostPath mount is still a bind mount, and a writable emptyDir/configMap/
+// PVC mount is not.
+func containerHasBindMounts(pod *api.Pod, container *api.Container) bool {
+	if len(container.VolumeMounts) == 0 {
+		return false
+	}
+	hostPathVolumes := make(map[string]bool, len(pod.Spec.Volumes))
+	for _, v := range pod.Spec.Volumes {
+		if v.VolumeSource.HostPath != nil {
+			hostPathVolumes[v.Name] = true
+		}
+	}
+	for _, vm := range container.VolumeMounts {
+		if hostPathVolumes[vm.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// ociContainerState is the minimal OCI runtime state JSON (see the OCI
+// runtime spec's `state.json`) that hooks receive on stdin. Real container
+// state fields beyond these aren't needed by the match/run contract hooks
+// rely on.
+type ociContainerState struct {
+	Version string `json:"ociVersion"`
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Pid     int    `json:"pid,omitempty"`
+	Bundle  string `json:"bundle"`
+}
+
+// runHooks runs every hook matching stage for pod/container/containerID,
+// each with the configurable timeout, and reports failures as Warning
+// events through recorder without returning an error: a misbehaving hook
+// must not fail the pod.
+func (m *kubeGenericRuntimeManager) runHooks(stage ociHookStage, pod *api.Pod, container *api.Container, containerID kubecontainer.ContainerID, status string) {
+	if m.ociHooks == nil {
+		return
+	}
+
+	hooks := m.ociHooks.matchingHooks(stage, pod, container, containerHasBindMounts(pod, container))
+	if len(hooks) == 0 {
+		return
+	}
+
+	state, err := json.Marshal(ociContainerState{
+		Version: "1.0.0",
+		ID:      containerID.ID,
+		Status:  status,
+		Bundle:  containerID.ID,
+	})
+	if err != nil {
+		glog.Errorf("Error marshaling OCI state for hooks on container %q: %v", containerID, err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if err := runOCIHook(hook, state, m.ociHooks.timeout); err != nil {
+			glog.Warningf("OCI %s hook %q failed for container %q: %v", stage, hook.Hook, containerID, err)
+			m.generateContainerEvent(containerID, api.EventTypeWarning, failedOCIHookEvent,
+				fmt.Sprintf("OCI %s hook %q failed: %v", stage, hook.Hook, err))
+		}
+	}
+}
+
+// runOCIHook execs a single hook's command, feeding it state on stdin and
+// bounding it with timeout.
+func runOCIHook(hook *ociHook, state []byte, timeout time.Duration) error {
+	cmd := exec.Command(hook.Hook, hook.Arguments...)
+	cmd.Stdin = bytes.NewReader(state)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start hook: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, stderr.String())
+		}
+		return nil
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("hook timed out after %v", timeout)
+	}
+}
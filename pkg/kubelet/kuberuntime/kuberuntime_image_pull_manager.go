@@ -0,0 +1,217 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/api"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/events"
+	"k8s.io/kubernetes/pkg/kubelet/images"
+)
+
+const (
+	// defaultMaxParallelImagePulls bounds how many image pulls can be in
+	// flight across the whole node at once, regardless of registry.
+	defaultMaxParallelImagePulls = 5
+
+	// defaultMaxPullsPerRegistry further bounds how many of those pulls can
+	// be hitting the same registry host at once, so a single rate-limited
+	// registry doesn't eat the whole global budget and starve others.
+	defaultMaxPullsPerRegistry = 3
+)
+
+// pullResult is what every waiter on a coalesced pull receives once it
+// completes.
+type pullResult struct {
+	msg string
+	err error
+}
+
+// imagePullRequest is one container's ask to have its image present;
+// requests naming the same image reference are coalesced into a single
+// underlying pull.
+type imagePullRequest struct {
+	pod         *api.Pod
+	container   *api.Container
+	pullSecrets []api.Secret
+	resultCh    chan pullResult
+}
+
+// imagePullManager sits in front of images.ImageManager and turns
+// startContainer's synchronous, unbounded EnsureImageExists calls into a
+// bounded, per-registry-serialized, duplicate-coalescing pipeline. This
+// keeps a node that just scheduled dozens of pods from hammering one
+// registry with concurrent layer pulls while still letting pulls against
+// different registries run in parallel.
+type imagePullManager struct {
+	puller   images.ImageManager
+	recorder record.EventRecorder
+
+	serialize bool
+
+	// global bounds total in-flight pulls; registrySems bounds in-flight
+	// pulls per registry host.
+	global         chan struct{}
+	registryMu     sync.Mutex
+	registrySems   map[string]chan struct{}
+	maxPerRegistry int
+
+	// inFlight coalesces duplicate pulls of the same image reference: the
+	// first caller starts the pull and fans its result out to every other
+	// caller waiting on the same key.
+	inFlightMu sync.Mutex
+	inFlight   map[string][]chan pullResult
+}
+
+// newImagePullManager constructs an imagePullManager. maxParallelPulls and
+// maxPullsPerRegistry fall back to their package defaults when <= 0.
+// serialize, when true, collapses maxParallelPulls to 1, matching the
+// existing --serialize-image-pulls kubelet flag's semantics.
+func newImagePullManager(puller images.ImageManager, recorder record.EventRecorder, serialize bool, maxParallelPulls, maxPullsPerRegistry int) *imagePullManager {
+	if maxParallelPulls <= 0 {
+		maxParallelPulls = defaultMaxParallelImagePulls
+	}
+	if serialize {
+		maxParallelPulls = 1
+	}
+	if maxPullsPerRegistry <= 0 {
+		maxPullsPerRegistry = defaultMaxPullsPerRegistry
+	}
+	return &imagePullManager{
+		puller:         puller,
+		recorder:       recorder,
+		serialize:      serialize,
+		global:         make(chan struct{}, maxParallelPulls),
+		registrySems:   make(map[string]chan struct{}),
+		maxPerRegistry: maxPullsPerRegistry,
+		inFlight:       make(map[string][]chan pullResult),
+	}
+}
+
+// registrySemaphore returns (creating if necessary) the per-registry
+// concurrency gate for host.
+func (m *imagePullManager) registrySemaphore(host string) chan struct{} {
+	m.registryMu.Lock()
+	defer m.registryMu.Unlock()
+	sem, ok := m.registrySems[host]
+	if !ok {
+		sem = make(chan struct{}, m.maxPerRegistry)
+		m.registrySems[host] = sem
+	}
+	return sem
+}
+
+// EnsureImageExists pulls container's image if necessary, serialized
+// per-registry and bounded globally, coalescing with any other in-flight
+// pull of the same image reference. It blocks until the (possibly shared)
+// pull finishes.
+func (m *imagePullManager) EnsureImageExists(pod *api.Pod, container *api.Container, pullSecrets []api.Secret) (error, string) {
+	ref := container.Image
+	resultCh := make(chan pullResult, 1)
+
+	objRef, err := kubecontainer.GenerateContainerRef(pod, container)
+	if err != nil {
+		glog.Errorf("Can't make a ref to pod %q, container %v: %v", pod.Name, container.Name, err)
+	}
+
+	m.inFlightMu.Lock()
+	waiters, alreadyPulling := m.inFlight[ref]
+	m.inFlight[ref] = append(waiters, resultCh)
+	m.inFlightMu.Unlock()
+
+	if alreadyPulling {
+		m.recorder.Eventf(objRef, api.EventTypeNormal, events.PullingImage, "Waiting for in-flight pull of image %q", ref)
+		result := <-resultCh
+		return result.err, result.msg
+	}
+
+	host := registryHost(ref)
+	registrySem := m.registrySemaphore(host)
+
+	select {
+	case m.global <- struct{}{}:
+	default:
+		m.recorder.Eventf(objRef, api.EventTypeNormal, "PullThrottled", "Throttling pull of image %q: max parallel pulls in use", ref)
+		m.global <- struct{}{}
+	}
+	defer func() { <-m.global }()
+
+	select {
+	case registrySem <- struct{}{}:
+	default:
+		m.recorder.Eventf(objRef, api.EventTypeNormal, "PullThrottled", "Throttling pull of image %q: registry %q at its concurrency limit", ref, host)
+		registrySem <- struct{}{}
+	}
+	defer func() { <-registrySem }()
+
+	err, msg := m.puller.EnsureImageExists(pod, container, pullSecrets)
+
+	m.inFlightMu.Lock()
+	fanOut := m.inFlight[ref]
+	delete(m.inFlight, ref)
+	m.inFlightMu.Unlock()
+
+	result := pullResult{msg: msg, err: err}
+	// fanOut[0] is our own resultCh; everyone else is a waiter that piggy-
+	// backed on this pull.
+	for _, ch := range fanOut[1:] {
+		ch <- result
+	}
+	return err, msg
+}
+
+// registryHost extracts the registry host component from an image
+// reference so pulls can be serialized per-registry. Image references
+// without an explicit registry (e.g. "nginx:latest") are treated as
+// belonging to the implicit default registry.
+func registryHost(image string) string {
+	ref := image
+	if i := indexRune(ref, '/'); i == -1 {
+		return "docker.io"
+	} else if host := ref[:i]; looksLikeHost(host) {
+		return host
+	}
+	return "docker.io"
+}
+
+// looksLikeHost is a cheap heuristic distinguishing "myregistry.com/foo"
+// and "localhost:5000/foo" from a Docker Hub user/repo like "library/nginx":
+// a real registry host contains a dot, a colon, or is literally "localhost".
+func looksLikeHost(s string) bool {
+	if s == "localhost" {
+		return true
+	}
+	for _, r := range s {
+		if r == '.' || r == ':' {
+			return true
+		}
+	}
+	return false
+}
+
+func indexRune(s string, r rune) int {
+	for i, c := range s {
+		if c == r {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// fakeImagePuller is a test double for images.ImageManager that blocks until
+// released and records the order in which pulls actually started.
+type fakeImagePuller struct {
+	mu      sync.Mutex
+	started []string
+	release chan struct{}
+}
+
+func newFakeImagePuller() *fakeImagePuller {
+	return &fakeImagePuller{release: make(chan struct{})}
+}
+
+func (f *fakeImagePuller) EnsureImageExists(pod *api.Pod, container *api.Container, pullSecrets []api.Secret) (error, string) {
+	f.mu.Lock()
+	f.started = append(f.started, container.Image)
+	f.mu.Unlock()
+	<-f.release
+	return nil, ""
+}
+
+func TestImagePullManagerCoalescesDuplicatePulls(t *testing.T) {
+	puller := newFakeImagePuller()
+	m := newImagePullManager(puller, record.NewFakeRecorder(10), false, 4, 4)
+
+	const n = 5
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			err, _ := m.EnsureImageExists(&api.Pod{}, &api.Container{Image: "same-image"}, nil)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// Give every goroutine a chance to enqueue before releasing the pull.
+	time.Sleep(50 * time.Millisecond)
+	close(puller.release)
+	wg.Wait()
+
+	puller.mu.Lock()
+	defer puller.mu.Unlock()
+	if len(puller.started) != 1 {
+		t.Errorf("expected exactly 1 underlying pull for %d callers of the same image, got %d", n, len(puller.started))
+	}
+}
+
+func TestImagePullManagerBoundsGlobalConcurrency(t *testing.T) {
+	puller := newFakeImagePuller()
+	const maxParallel = 2
+	const n = 6
+	// Every image lives on the same default registry, so give that registry
+	// room for all n pulls: only the global bound can then hold them back.
+	m := newImagePullManager(puller, record.NewFakeRecorder(10), false, maxParallel, n)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		image := string(rune('a' + i))
+		go func(image string) {
+			defer wg.Done()
+			m.EnsureImageExists(&api.Pod{}, &api.Container{Image: image}, nil)
+		}(image)
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		puller.mu.Lock()
+		started := len(puller.started)
+		puller.mu.Unlock()
+		if started >= maxParallel {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("expected at least %d pulls to start, only saw %d", maxParallel, started)
+		default:
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	puller.mu.Lock()
+	started := len(puller.started)
+	puller.mu.Unlock()
+	if started > maxParallel {
+		t.Errorf("expected at most %d concurrent pulls, saw %d start before any completed", maxParallel, started)
+	}
+
+	close(puller.release)
+	wg.Wait()
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+)
+
+// CRI log lines are written by the runtime as:
+//   <RFC3339Nano timestamp> <stream> <tag> <message>\n
+// where stream is "stdout" or "stderr" and tag is "P" (partial - more of
+// this line follows in a later write) or "F" (full - the line ends here).
+const (
+	logStreamStdout = "stdout"
+	logStreamStderr = "stderr"
+
+	logTagPartial = "P"
+	logTagFull    = "F"
+
+	// logForcePollInterval is how often the follow loop polls the log file
+	// for new data and rotation; following is done by polling alone.
+	logForcePollInterval = 2 * time.Second
+)
+
+// logLine is a single parsed line from a CRI container log file.
+type logLine struct {
+	timestamp time.Time
+	stream    string
+	partial   bool
+	message   string
+}
+
+// parseLogLine splits one line of a CRI log file into its components.
+// Malformed lines (written by something other than the CRI shim) are passed
+// through verbatim on the stdout stream so a log reader never silently
+// drops data.
+func parseLogLine(raw string) (logLine, error) {
+	parts := strings.SplitN(raw, " ", 4)
+	if len(parts) != 4 {
+		return logLine{}, fmt.Errorf("unexpected CRI log line format: %q", raw)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return logLine{}, fmt.Errorf("invalid timestamp in CRI log line %q: %v", raw, err)
+	}
+	return logLine{
+		timestamp: ts,
+		stream:    parts[1],
+		partial:   parts[2] == logTagPartial,
+		message:   parts[3],
+	}, nil
+}
+
+// ReadLogs reads the CRI log file at path and writes it to stdout/stderr
+// according to opts, honoring every field of api.PodLogOptions. It's a
+// runtime-agnostic replacement for the dockershim.DockerLegacyService
+// type-assertion GetContainerLogs used to require.
+func ReadLogs(path string, opts *api.PodLogOptions, stdout, stderr io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %q: %v", path, err)
+	}
+	defer f.Close()
+
+	writers := map[string]io.Writer{
+		logStreamStdout: stdout,
+		logStreamStderr: stderr,
+	}
+
+	var since time.Time
+	if opts != nil {
+		if opts.SinceTime != nil {
+			since = opts.SinceTime.Time
+		} else if opts.SinceSeconds != nil {
+			since = time.Now().Add(-time.Duration(*opts.SinceSeconds) * time.Second)
+		}
+	}
+
+	var tailLines int64 = -1
+	if opts != nil && opts.TailLines != nil {
+		tailLines = *opts.TailLines
+	}
+	if tailLines >= 0 {
+		if err := seekTailLines(f, tailLines); err != nil {
+			return err
+		}
+	}
+
+	var limitBytes int64 = -1
+	if opts != nil && opts.LimitBytes != nil {
+		limitBytes = *opts.LimitBytes
+	}
+
+	timestamps := opts != nil && opts.Timestamps
+	follow := opts != nil && opts.Follow
+
+	written := int64(0)
+	reader := bufio.NewReader(f)
+	writeLine := func(line logLine) (bool, error) {
+		if !since.IsZero() && !line.timestamp.IsZero() && line.timestamp.Before(since) {
+			return true, nil
+		}
+		w := writers[line.stream]
+		if w == nil {
+			return true, nil
+		}
+		msg := line.message
+		if timestamps && !line.timestamp.IsZero() {
+			msg = line.timestamp.Format(time.RFC3339Nano) + " " + msg
+		}
+		if !line.partial {
+			msg += "\n"
+		}
+		if limitBytes >= 0 {
+			if written >= limitBytes {
+				return false, nil
+			}
+			if remaining := limitBytes - written; int64(len(msg)) > remaining {
+				msg = msg[:remaining]
+			}
+		}
+		n, err := io.WriteString(w, msg)
+		written += int64(n)
+		return true, err
+	}
+
+	for {
+		raw, err := reader.ReadString('\n')
+		if len(raw) > 0 {
+			line, perr := parseLogLine(strings.TrimSuffix(raw, "\n"))
+			if perr != nil {
+				glog.V(4).Infof("Passing through malformed CRI log line in %q verbatim: %v", path, perr)
+				line = logLine{stream: logStreamStdout, message: strings.TrimSuffix(raw, "\n")}
+			}
+			keepGoing, werr := writeLine(line)
+			if werr != nil {
+				return werr
+			}
+			if !keepGoing {
+				return nil
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			return fmt.Errorf("error reading log file %q: %v", path, err)
+		}
+		if !follow {
+			return nil
+		}
+		nextF, nextReader, done, err := followLogFile(path, f, reader, writeLine)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if nextF != f {
+			f.Close()
+			f = nextF
+			reader = nextReader
+		}
+	}
+}
+
+// followLogFile waits for more data to be appended to f, or for the log to
+// rotate (detected by the inode changing or the file shrinking under us —
+// both of which kubelet's own log rotation does on a container restart). On
+// rotation it first drains whatever was already written to the old
+// descriptor before dropping it, so no lines written right up to the rotate
+// are lost, then reopens the current path. done reports that writeLine asked
+// the caller (via its limitBytes early-exit) to stop following entirely.
+func followLogFile(path string, f *os.File, reader *bufio.Reader, writeLine func(logLine) (bool, error)) (nextF *os.File, nextReader *bufio.Reader, done bool, err error) {
+	startInfo, err := f.Stat()
+	if err != nil {
+		return f, reader, false, fmt.Errorf("error stating log file %q: %v", path, err)
+	}
+
+	for {
+		time.Sleep(logForcePollInterval)
+
+		curInfo, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// The file may reappear once the container finishes rotating;
+			// keep waiting on the descriptor we already have.
+			continue
+		}
+		if err != nil {
+			return f, reader, false, fmt.Errorf("error stating log file %q: %v", path, err)
+		}
+
+		rotated := !os.SameFile(startInfo, curInfo) || curInfo.Size() < startInfo.Size()
+		if rotated {
+			keepGoing, err := drainToEOF(path, reader, writeLine)
+			if err != nil {
+				return f, reader, false, err
+			}
+			if !keepGoing {
+				return f, reader, true, nil
+			}
+			newF, err := os.Open(path)
+			if err != nil {
+				return f, reader, false, fmt.Errorf("error reopening rotated log file %q: %v", path, err)
+			}
+			return newF, bufio.NewReader(newF), false, nil
+		}
+
+		if curInfo.Size() > startInfo.Size() {
+			return f, reader, false, nil
+		}
+	}
+}
+
+// drainToEOF reads and processes every remaining line from reader before a
+// rotated file descriptor is dropped, so nothing written between the last
+// read and the rotation is lost. It reports whether the caller should keep
+// following (false if writeLine's limitBytes cutoff was hit).
+func drainToEOF(path string, reader *bufio.Reader, writeLine func(logLine) (bool, error)) (bool, error) {
+	for {
+		raw, err := reader.ReadString('\n')
+		if len(raw) > 0 {
+			line, perr := parseLogLine(strings.TrimSuffix(raw, "\n"))
+			if perr != nil {
+				glog.V(4).Infof("Passing through malformed CRI log line in %q verbatim: %v", path, perr)
+				line = logLine{stream: logStreamStdout, message: strings.TrimSuffix(raw, "\n")}
+			}
+			keepGoing, werr := writeLine(line)
+			if werr != nil {
+				return false, werr
+			}
+			if !keepGoing {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error draining rotated log file %q: %v", path, err)
+		}
+	}
+}
+
+// seekTailLines seeks f so that reading from the current position returns
+// only the last n lines, by scanning backwards counting '\n' bytes.
+func seekTailLines(f *os.File, n int64) error {
+	if n == 0 {
+		_, err := f.Seek(0, io.SeekEnd)
+		return err
+	}
+
+	const chunkSize = 32 * 1024
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var newlines int64
+	pos := fileSize
+	buf := make([]byte, chunkSize)
+	for pos > 0 {
+		readSize := int64(chunkSize)
+		if readSize > pos {
+			readSize = pos
+		}
+		pos -= readSize
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(f, buf[:readSize]); err != nil {
+			return err
+		}
+		for i := readSize - 1; i >= 0; i-- {
+			if buf[i] == '\n' {
+				newlines++
+				if newlines > n {
+					if _, err := f.Seek(pos+i+1, io.SeekStart); err != nil {
+						return err
+					}
+					return nil
+				}
+			}
+		}
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// GetContainerLogs returns logs of a specific container by reading the CRI
+// log file kubelet asked the runtime to write to at create time.
+func (m *kubeGenericRuntimeManager) GetContainerLogs(pod *api.Pod, containerID kubecontainer.ContainerID, logOptions *api.PodLogOptions, stdout, stderr io.Writer) error {
+	status, err := m.runtimeService.ContainerStatus(containerID.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get container status %q: %v", containerID.String(), err)
+	}
+	labeledInfo := getContainerInfoFromLabels(status.Labels)
+	logPath := getContainerLogsPath(labeledInfo.ContainerName, pod.UID)
+
+	return ReadLogs(logPath, logOptions, stdout, stderr)
+}
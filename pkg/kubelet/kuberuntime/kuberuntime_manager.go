@@ -0,0 +1,87 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"k8s.io/client-go/tools/record"
+	runtimeApi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/images"
+)
+
+// kubeGenericRuntimeManager integrates the CRI RuntimeService/ImageService
+// with kubelet's generic runtime interface. Most of its methods live in the
+// kuberuntime_*.go siblings of this file; this file holds the type itself.
+type kubeGenericRuntimeManager struct {
+	runtimeName string
+	recorder    record.EventRecorder
+
+	// containerRefManager tracks the api.ObjectReference for containers so
+	// events can be recorded against them after creation.
+	containerRefManager *kubecontainer.RefManager
+
+	// runtimeHelper is the bridge back into kubelet for things the manager
+	// can't compute on its own (DNS, mounts, downward API, etc).
+	runtimeHelper kubecontainer.RuntimeHelper
+
+	// runner executes the PostStart/PreStop container lifecycle handlers.
+	runner kubecontainer.HandlerRunner
+
+	// imagePuller wraps the CRI ImageService with image pull backoff.
+	imagePuller images.ImageManager
+
+	// imagePullManager sits in front of imagePuller, bounding concurrent
+	// pulls and serializing them per-registry. serializeImagePulls,
+	// maxParallelImagePulls and maxPullsPerRegistry configure it; they're
+	// plumbed through from the kubelet's --serialize-image-pulls,
+	// --max-parallel-image-pulls and --max-pulls-per-registry flags.
+	imagePullManager      *imagePullManager
+	serializeImagePulls   bool
+	maxParallelImagePulls int
+	maxPullsPerRegistry   int
+
+
+	// cpuCFSQuota enables CPU CFS quota enforcement for containers that
+	// request a CPU limit.
+	cpuCFSQuota bool
+
+	// runtimeService and imageService are the CRI clients this manager
+	// drives; runtimeService is type-asserted against narrower interfaces
+	// (streamingRuntimeService, dockershim.DockerLegacyService) where a
+	// capability is optional.
+	runtimeService runtimeApi.RuntimeService
+	imageService   runtimeApi.ImageManagerService
+
+	// streamingFallback serves Attach/Exec/PortForward in-process for CRI
+	// runtimes that don't return a redirect URL from the streaming RPCs.
+	streamingFallback *streamingFallbackServer
+
+	// ociHooks runs the OCI prestart/poststart/poststop hooks configured on
+	// this node, if any. Nil when no hooks directory is configured.
+	ociHooks *ociHookManager
+
+	// containerStatusWorkers bounds how many ContainerStatus RPCs
+	// getKubeletContainerStatuses fans out at once; <= 0 means
+	// defaultContainerStatusWorkers.
+	containerStatusWorkers int
+
+	// maxExitedContainerStatusesPerContainer bounds how many non-latest
+	// exited containers getKubeletContainerStatuses fetches status for, per
+	// container name; <= 0 means
+	// defaultMaxExitedContainerStatusesPerContainer.
+	maxExitedContainerStatusesPerContainer int
+}
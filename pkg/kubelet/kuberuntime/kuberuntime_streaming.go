@@ -0,0 +1,151 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	runtimeApi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/dockershim"
+	"k8s.io/kubernetes/pkg/util/term"
+)
+
+// streamingRuntimeService is the subset of the CRI RuntimeService that
+// kubeGenericRuntimeManager needs to satisfy Exec/Attach/PortForward through
+// the URL-redirect pattern used by CRI-O and podman's server package: the
+// runtime answers each RPC with a one-shot URL, and kubelet dials that URL
+// over SPDY and proxies the client's streams to it.
+type streamingRuntimeService interface {
+	Exec(*runtimeApi.ExecRequest) (*runtimeApi.ExecResponse, error)
+	Attach(*runtimeApi.AttachRequest) (*runtimeApi.AttachResponse, error)
+	PortForward(*runtimeApi.PortForwardRequest) (*runtimeApi.PortForwardResponse, error)
+}
+
+// AttachContainer attaches to the container's console.
+func (m *kubeGenericRuntimeManager) AttachContainer(id kubecontainer.ContainerID, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error {
+	hasStdin := stdin != nil
+	if streamingService, ok := m.runtimeService.(streamingRuntimeService); ok {
+		resp, err := streamingService.Attach(&runtimeApi.AttachRequest{
+			ContainerId: &id.ID,
+			Stdin:       &hasStdin,
+			Tty:         &tty,
+		})
+		if err != nil {
+			return fmt.Errorf("CRI Attach failed: %v", err)
+		}
+		if resp.GetUrl() != "" {
+			return m.redirectStream(resp.GetUrl(), stdin, stdout, stderr, tty, resize)
+		}
+		if m.streamingFallback == nil {
+			return fmt.Errorf("runtime did not provide a streaming URL and no local attach fallback is configured")
+		}
+		return m.streamingFallback.attach(id, stdin, stdout, stderr, tty, resize)
+	}
+
+	// Fall back to the legacy docker-specific path for runtimes that haven't
+	// migrated to the CRI streaming RPCs yet.
+	if ds, ok := m.runtimeService.(dockershim.DockerLegacyService); ok {
+		return ds.AttachContainer(id, stdin, stdout, stderr, tty, resize)
+	}
+	return fmt.Errorf("runtime does not support attach")
+}
+
+// ExecInContainer runs cmd in the container, streaming stdin/stdout/stderr
+// through whichever path the runtime supports: a CRI-provided redirect URL,
+// the in-process fallback server, or (for runtimes that predate CRI exec)
+// the legacy dockershim path. Terminal resize events on resize are passed
+// along to whichever path is used.
+func (m *kubeGenericRuntimeManager) ExecInContainer(containerID kubecontainer.ContainerID, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error {
+	hasStdin := stdin != nil
+	if streamingService, ok := m.runtimeService.(streamingRuntimeService); ok {
+		resp, err := streamingService.Exec(&runtimeApi.ExecRequest{
+			ContainerId: &containerID.ID,
+			Cmd:         cmd,
+			Tty:         &tty,
+			Stdin:       &hasStdin,
+		})
+		if err != nil {
+			return fmt.Errorf("CRI Exec failed: %v", err)
+		}
+		if resp.GetUrl() != "" {
+			return m.redirectStream(resp.GetUrl(), stdin, stdout, stderr, tty, resize)
+		}
+		if m.streamingFallback == nil {
+			return fmt.Errorf("runtime did not provide a streaming URL and no local exec fallback is configured")
+		}
+		return m.streamingFallback.exec(containerID, cmd, stdin, stdout, stderr, tty, resize)
+	}
+
+	// Use `docker exec` directly for in-process docker integration for
+	// now to unblock other tests.
+	if ds, ok := m.runtimeService.(dockershim.DockerLegacyService); ok {
+		return ds.ExecInContainer(containerID, cmd, stdin, stdout, stderr, tty, resize)
+	}
+	return fmt.Errorf("runtime does not support exec")
+}
+
+// PortForward proxies a single port of a pod sandbox between stream and
+// whichever path the runtime supports.
+func (m *kubeGenericRuntimeManager) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	streamingService, ok := m.runtimeService.(streamingRuntimeService)
+	if !ok {
+		if m.streamingFallback == nil {
+			return fmt.Errorf("runtime does not support port-forward and no local fallback is configured")
+		}
+		return m.streamingFallback.portForward(podSandboxID, port, stream)
+	}
+
+	resp, err := streamingService.PortForward(&runtimeApi.PortForwardRequest{
+		PodSandboxId: &podSandboxID,
+		Port:         []int32{port},
+	})
+	if err != nil {
+		return fmt.Errorf("CRI PortForward failed: %v", err)
+	}
+	if resp.GetUrl() == "" {
+		if m.streamingFallback == nil {
+			return fmt.Errorf("runtime did not provide a streaming URL and no local port-forward fallback is configured")
+		}
+		return m.streamingFallback.portForward(podSandboxID, port, stream)
+	}
+	return m.redirectPortForwardStream(resp.GetUrl(), port, stream)
+}
+
+// redirectStream dials the one-shot URL returned by the runtime over SPDY
+// and proxies stdin/stdout/stderr and, if tty is set, terminal resize events
+// between it and the caller's streams until the connection closes.
+func (m *kubeGenericRuntimeManager) redirectStream(rawURL string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid streaming URL %q from runtime: %v", rawURL, err)
+	}
+	return dialAndPumpSPDY(target, stdin, stdout, stderr, tty, resize)
+}
+
+// redirectPortForwardStream is the port-forward analogue of redirectStream:
+// it proxies a single port's bytes between the caller's stream and the
+// runtime-provided URL.
+func (m *kubeGenericRuntimeManager) redirectPortForwardStream(rawURL string, port int32, stream io.ReadWriteCloser) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid streaming URL %q from runtime: %v", rawURL, err)
+	}
+	return dialAndPumpPortForwardSPDY(target, port, stream)
+}
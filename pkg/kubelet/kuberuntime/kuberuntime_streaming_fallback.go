@@ -0,0 +1,69 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"fmt"
+	"io"
+
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/util/term"
+)
+
+// streamingFallbackServer implements Attach/Exec/PortForward entirely
+// in-process, for CRI runtimes that don't return a redirect URL. It reuses
+// the exact io.Reader/WriteCloser and term.Size signatures already required
+// of AttachContainer/ExecInContainer so it can be dropped in as the last
+// resort without changing any caller.
+//
+// runner is the thing that actually knows how to get inside the container
+// (e.g. nsenter into its namespaces); it is swappable so the manager can be
+// constructed without one when every runtime it talks to supports the
+// URL-redirect path.
+type streamingFallbackServer struct {
+	runner streamingFallbackRunner
+}
+
+// streamingFallbackRunner is implemented by runtimes/shims that can execute
+// a command inside a container's namespaces without going through a CRI
+// streaming RPC.
+type streamingFallbackRunner interface {
+	RunInContainer(id kubecontainer.ContainerID, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error
+	AttachInContainer(id kubecontainer.ContainerID, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error
+	ForwardPort(podSandboxID string, port int32, stream io.ReadWriteCloser) error
+}
+
+func (s *streamingFallbackServer) exec(id kubecontainer.ContainerID, cmd []string, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error {
+	if s.runner == nil {
+		return fmt.Errorf("runtime did not provide a streaming URL and no local exec fallback is configured")
+	}
+	return s.runner.RunInContainer(id, cmd, stdin, stdout, stderr, tty, resize)
+}
+
+func (s *streamingFallbackServer) attach(id kubecontainer.ContainerID, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error {
+	if s.runner == nil {
+		return fmt.Errorf("runtime did not provide a streaming URL and no local attach fallback is configured")
+	}
+	return s.runner.AttachInContainer(id, stdin, stdout, stderr, tty, resize)
+}
+
+func (s *streamingFallbackServer) portForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	if s.runner == nil {
+		return fmt.Errorf("runtime did not provide a streaming URL and no local port-forward fallback is configured")
+	}
+	return s.runner.ForwardPort(podSandboxID, port, stream)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/httpstream"
+	"k8s.io/apimachinery/pkg/httpstream/spdy"
+	"k8s.io/kubernetes/pkg/util/term"
+)
+
+const (
+	streamTypeStdin  = "stdin"
+	streamTypeStdout = "stdout"
+	streamTypeStderr = "stderr"
+	streamTypeResize = "resize"
+	streamTypeData   = "data"
+
+	v4StreamProtocol = "v4.channel.k8s.io"
+)
+
+// dialAndPumpSPDY upgrades an HTTP connection to the runtime-provided URL
+// into a SPDY connection, opens the streams the caller asked for, and pumps
+// bytes until the remote side closes the connection. A non-empty message on
+// the v4 protocol's error stream is returned as this call's error.
+func dialAndPumpSPDY(target *url.URL, stdin io.Reader, stdout, stderr io.WriteCloser, tty bool, resize <-chan term.Size) error {
+	conn, _, err := spdy.Dial(spdy.RoundTripperFor(target), &http.Client{}, &http.Request{Method: "POST", URL: target}, v4StreamProtocol)
+	if err != nil {
+		return fmt.Errorf("error dialing streaming URL %q: %v", target, err)
+	}
+	defer conn.Close()
+
+	errorStream, err := openClientStream(conn, "error")
+	if err != nil {
+		return err
+	}
+	defer errorStream.Reset()
+
+	errMsgCh := make(chan string, 1)
+	go func() {
+		var errMsg bytes.Buffer
+		io.Copy(&errMsg, errorStream)
+		errMsgCh <- errMsg.String()
+	}()
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	if stdin != nil {
+		if inStream, err := openClientStream(conn, streamTypeStdin); err == nil {
+			go func() {
+				io.Copy(inStream, stdin)
+				inStream.Close()
+			}()
+		}
+	}
+	if tty && resize != nil {
+		if resizeStream, err := openClientStream(conn, streamTypeResize); err == nil {
+			go pumpResize(resizeStream, resize, doneCh)
+		}
+	}
+
+	outDone := make(chan error, 1)
+	if stdout != nil {
+		outStream, err := openClientStream(conn, streamTypeStdout)
+		if err != nil {
+			return err
+		}
+		go func() {
+			_, copyErr := io.Copy(stdout, outStream)
+			outDone <- copyErr
+		}()
+	} else {
+		outDone <- nil
+	}
+
+	errDone := make(chan error, 1)
+	if stderr != nil && !tty {
+		errStream, err := openClientStream(conn, streamTypeStderr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			_, copyErr := io.Copy(stderr, errStream)
+			errDone <- copyErr
+		}()
+	} else {
+		errDone <- nil
+	}
+
+	if err := <-outDone; err != nil && err != io.EOF {
+		return err
+	}
+	if err := <-errDone; err != nil && err != io.EOF {
+		return err
+	}
+
+	// The runtime writes a non-empty error stream when the in-container
+	// command couldn't be run or exited non-zero; surface it as the call's
+	// error instead of silently reporting success.
+	conn.Close()
+	if errMsg := <-errMsgCh; errMsg != "" {
+		return fmt.Errorf("error executing command in container: %s", errMsg)
+	}
+	return nil
+}
+
+// dialAndPumpPortForwardSPDY is the port-forward analogue of
+// dialAndPumpSPDY: it opens a single data stream tagged with the requested
+// port and proxies bytes in both directions.
+func dialAndPumpPortForwardSPDY(target *url.URL, port int32, stream io.ReadWriteCloser) error {
+	conn, _, err := spdy.Dial(spdy.RoundTripperFor(target), &http.Client{}, &http.Request{Method: "POST", URL: target}, v4StreamProtocol)
+	if err != nil {
+		return fmt.Errorf("error dialing streaming URL %q: %v", target, err)
+	}
+	defer conn.Close()
+
+	headers := http.Header{}
+	headers.Set("streamType", streamTypeData)
+	headers.Set("port", strconv.Itoa(int(port)))
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return fmt.Errorf("error creating port-forward stream for port %d: %v", port, err)
+	}
+	defer dataStream.Reset()
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(dataStream, stream)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(stream, dataStream)
+		errCh <- err
+	}()
+	if err := <-errCh; err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func openClientStream(conn httpstream.Connection, streamType string) (httpstream.Stream, error) {
+	headers := http.Header{}
+	headers.Set("streamType", streamType)
+	stream, err := conn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s stream: %v", streamType, err)
+	}
+	return stream, nil
+}
+
+func pumpResize(stream httpstream.Stream, resize <-chan term.Size, done <-chan struct{}) {
+	for {
+		select {
+		case size, ok := <-resize:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(stream, `{"Width":%d,"Height":%d}`, size.Width, size.Height)
+		case <-done:
+			return
+		}
+	}
+}
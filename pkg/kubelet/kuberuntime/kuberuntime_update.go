@@ -0,0 +1,56 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	"fmt"
+
+	"k8s.io/kubernetes/pkg/api"
+	runtimeApi "k8s.io/kubernetes/pkg/kubelet/api/v1alpha1/runtime"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	"k8s.io/kubernetes/pkg/kubelet/events"
+)
+
+// updateContainerResourcesRuntimeService is implemented by CRI runtimes
+// (CRI-O's container_updateruntimeconfig RPC, for example) that can change a
+// running container's cgroup limits without restarting it.
+type updateContainerResourcesRuntimeService interface {
+	UpdateContainerResources(containerID string, resources *runtimeApi.LinuxContainerResources) error
+}
+
+// UpdateContainerResources updates a running container's CPU/memory limits
+// in place, without killing and re-creating it, by translating resources
+// through the same milliCPU-to-shares/quota conversion startContainer uses
+// and issuing a CRI UpdateContainerResources call. This is the foundation
+// kubelet needs to react to pod spec resource-field mutations.
+func (m *kubeGenericRuntimeManager) UpdateContainerResources(containerID kubecontainer.ContainerID, resources api.ResourceRequirements) error {
+	updater, ok := m.runtimeService.(updateContainerResourcesRuntimeService)
+	if !ok {
+		return fmt.Errorf("runtime does not support updating container resources")
+	}
+
+	linuxResources := m.generateLinuxContainerResources(resources)
+	if err := updater.UpdateContainerResources(containerID.ID, linuxResources); err != nil {
+		m.generateContainerEvent(containerID, api.EventTypeWarning, events.FailedToUpdateContainer,
+			fmt.Sprintf("Failed to update resources for container %q: %v", containerID, err))
+		return err
+	}
+
+	m.generateContainerEvent(containerID, api.EventTypeNormal, "ResourcesUpdated",
+		fmt.Sprintf("Updated resources for container %q", containerID))
+	return nil
+}